@@ -0,0 +1,83 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package eventshub
+
+import (
+	nethttp "net/http"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// EventKind categorizes an EventInfo record.
+type EventKind string
+
+const (
+	// EventSent is vented once for every send, after its last delivery attempt, successful or not.
+	EventSent EventKind = "sent"
+
+	// EventResponse is vented for what the sink protocol returned for a successful send: the
+	// HTTP response, or the acknowledgement metadata of protocols with no response message of
+	// their own.
+	EventResponse EventKind = "response"
+
+	// EventSentRetry is vented for a delivery attempt that failed and is about to be retried.
+	EventSentRetry EventKind = "sentretry"
+
+	// EventSenderExited is vented once a sender's send loop has stopped, successfully or not.
+	EventSenderExited EventKind = "senderexited"
+)
+
+// EventInfo is one recorded occurrence in a test scenario's event log, vented by test images so
+// the scenario driving them can assert on what happened.
+type EventInfo struct {
+	Kind        EventKind
+	Event       *cloudevents.Event
+	Origin      string
+	Observer    string
+	Time        time.Time
+	Sequence    uint64
+	SentId      string
+	Error       string
+	Body        []byte
+	HTTPHeaders nethttp.Header
+	StatusCode  int
+
+	// Ack carries transport-specific acknowledgement metadata (e.g. a Kafka partition/offset or
+	// a NATS subject) for sink protocols with no structured response message of their own.
+	Ack string
+
+	// TraceId and SpanId identify the tracing span this record was emitted under, when tracing
+	// is enabled.
+	TraceId string
+	SpanId  string
+}
+
+// EventLogs collects the EventInfo records vented by a test image for later assertions.
+type EventLogs struct {
+	mu   sync.Mutex
+	logs []EventInfo
+}
+
+// Vent records info, making it visible to whatever is asserting on this EventLogs.
+func (l *EventLogs) Vent(info EventInfo) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logs = append(l.logs, info)
+	return nil
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sender
+
+import (
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"knative.dev/pkg/logging"
+
+	"knative.dev/reconciler-test/pkg/test_images/eventshub"
+)
+
+// sendMetrics are the Prometheus series exposed on the admin server's /metrics endpoint.
+type sendMetrics struct {
+	registry *prometheus.Registry
+	sent     prometheus.Counter
+	failed   prometheus.Counter
+	retried  prometheus.Counter
+	latency  prometheus.Histogram
+}
+
+func newSendMetrics() *sendMetrics {
+	m := &sendMetrics{
+		registry: prometheus.NewRegistry(),
+		sent: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "eventshub_sender_sent_total",
+			Help: "Total number of events the sender attempted to send.",
+		}),
+		failed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "eventshub_sender_failed_total",
+			Help: "Total number of events the sender failed to deliver.",
+		}),
+		retried: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "eventshub_sender_retried_total",
+			Help: "Total number of delivery attempts that were retried.",
+		}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "eventshub_sender_send_duration_seconds",
+			Help:    "Time to send an event, including retries.",
+			Buckets: prometheus.DefBuckets,
+		}),
+	}
+	m.registry.MustRegister(m.sent, m.failed, m.retried, m.latency)
+	return m
+}
+
+// recordSent records the outcome and latency of one logical send, including any retries.
+func (m *sendMetrics) recordSent(err error, duration time.Duration) {
+	m.sent.Inc()
+	if err != nil {
+		m.failed.Inc()
+	}
+	m.latency.Observe(duration.Seconds())
+}
+
+// recordRetry records a single delivery attempt that failed and is about to be retried.
+func (m *sendMetrics) recordRetry() {
+	m.retried.Inc()
+}
+
+// adminServer exposes /healthz, /readyz, /metrics and /shutdown while Start runs.
+type adminServer struct {
+	srv *nethttp.Server
+}
+
+// beginShutdown marks the generator as draining; hasNext starts returning false.
+func (g *generator) beginShutdown() {
+	atomic.StoreInt32(&g.draining, 1)
+}
+
+func (g *generator) isDraining() bool {
+	return atomic.LoadInt32(&g.draining) != 0
+}
+
+// newAdminMux builds the /healthz, /readyz, /metrics and /shutdown handlers.
+func (g *generator) newAdminMux() *nethttp.ServeMux {
+	mux := nethttp.NewServeMux()
+	mux.HandleFunc("/healthz", func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if atomic.LoadInt32(&g.initialized) == 0 {
+			w.WriteHeader(nethttp.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(nethttp.StatusOK)
+	})
+	mux.HandleFunc("/readyz", func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if atomic.LoadInt32(&g.ready) == 0 {
+			w.WriteHeader(nethttp.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(nethttp.StatusOK)
+	})
+	mux.HandleFunc("/shutdown", func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.Method != nethttp.MethodPost {
+			w.WriteHeader(nethttp.StatusMethodNotAllowed)
+			return
+		}
+		g.beginShutdown()
+		w.WriteHeader(nethttp.StatusOK)
+	})
+	mux.Handle("/metrics", promhttp.HandlerFor(g.metrics.registry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+// startAdminServer starts the admin HTTP server when AdminPort is set, returning a nil
+// *adminServer when disabled.
+func (g *generator) startAdminServer(ctx context.Context) (*adminServer, error) {
+	if g.AdminPort == 0 {
+		return nil, nil
+	}
+
+	srv := &nethttp.Server{Addr: ":" + strconv.Itoa(g.AdminPort), Handler: g.newAdminMux()}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != nethttp.ErrServerClosed {
+			logging.FromContext(ctx).Error("admin server exited: ", err)
+		}
+	}()
+
+	return &adminServer{srv: srv}, nil
+}
+
+func (a *adminServer) shutdown(ctx context.Context) {
+	if a == nil {
+		return
+	}
+	_ = a.srv.Shutdown(ctx)
+}
+
+// watchShutdownSignal begins the loop's drain on SIGTERM. The returned func stops watching.
+func (g *generator) watchShutdownSignal() func() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-sigCh:
+			g.beginShutdown()
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigCh)
+		close(done)
+	}
+}
+
+// drainAndExit waits for in-flight sends to finish, bounded by DrainTimeout, then vents a final
+// EventSenderExited record describing how the sender stopped.
+func (g *generator) drainAndExit(ctx context.Context, logs *eventshub.EventLogs, runErr error) error {
+	timeout := time.Duration(g.DrainTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		g.inFlight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(timeout):
+		logging.FromContext(ctx).Warn("drain timeout exceeded, exiting with in-flight requests outstanding")
+	}
+
+	exitInfo := eventshub.EventInfo{
+		Kind:     eventshub.EventSenderExited,
+		Origin:   g.SenderName,
+		Observer: g.SenderName,
+		Time:     time.Now(),
+		Sequence: uint64(atomic.LoadInt64(&g.sequence)),
+	}
+	if runErr != nil {
+		exitInfo.Error = runErr.Error()
+	}
+	if err := logs.Vent(exitInfo); err != nil && runErr == nil {
+		return fmt.Errorf("cannot forward event info: %w", err)
+	}
+
+	return runErr
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sender
+
+import (
+	"context"
+	nethttp "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"knative.dev/reconciler-test/pkg/test_images/eventshub"
+)
+
+func TestHealthzBeforeAndAfterInitialized(t *testing.T) {
+	g := &generator{metrics: newSendMetrics()}
+	mux := g.newAdminMux()
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(nethttp.MethodGet, "/healthz", nil))
+	if rec.Code != nethttp.StatusServiceUnavailable {
+		t.Errorf("/healthz before init = %d, want %d", rec.Code, nethttp.StatusServiceUnavailable)
+	}
+
+	g.initialized = 1
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(nethttp.MethodGet, "/healthz", nil))
+	if rec.Code != nethttp.StatusOK {
+		t.Errorf("/healthz after init = %d, want %d", rec.Code, nethttp.StatusOK)
+	}
+}
+
+func TestReadyzBeforeAndAfterProbe(t *testing.T) {
+	g := &generator{metrics: newSendMetrics()}
+	mux := g.newAdminMux()
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(nethttp.MethodGet, "/readyz", nil))
+	if rec.Code != nethttp.StatusServiceUnavailable {
+		t.Errorf("/readyz before probe = %d, want %d", rec.Code, nethttp.StatusServiceUnavailable)
+	}
+
+	g.ready = 1
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(nethttp.MethodGet, "/readyz", nil))
+	if rec.Code != nethttp.StatusOK {
+		t.Errorf("/readyz after probe = %d, want %d", rec.Code, nethttp.StatusOK)
+	}
+}
+
+func TestShutdownRequiresPost(t *testing.T) {
+	g := &generator{metrics: newSendMetrics()}
+	mux := g.newAdminMux()
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(nethttp.MethodGet, "/shutdown", nil))
+	if rec.Code != nethttp.StatusMethodNotAllowed {
+		t.Errorf("GET /shutdown = %d, want %d", rec.Code, nethttp.StatusMethodNotAllowed)
+	}
+	if g.isDraining() {
+		t.Error("isDraining() = true after a GET /shutdown, want false")
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(nethttp.MethodPost, "/shutdown", nil))
+	if rec.Code != nethttp.StatusOK {
+		t.Errorf("POST /shutdown = %d, want %d", rec.Code, nethttp.StatusOK)
+	}
+	if !g.isDraining() {
+		t.Error("isDraining() = false after a POST /shutdown, want true")
+	}
+}
+
+func TestDrainAndExitWaitsForInFlight(t *testing.T) {
+	g := &generator{metrics: newSendMetrics(), DrainTimeout: 5}
+	g.inFlight.Add(1)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- g.drainAndExit(context.Background(), &eventshub.EventLogs{}, nil)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("drainAndExit() returned before the in-flight send finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	g.inFlight.Done()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("drainAndExit() error = %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("drainAndExit() did not return after the in-flight send finished")
+	}
+}
+
+func TestDrainAndExitTimesOut(t *testing.T) {
+	g := &generator{metrics: newSendMetrics(), DrainTimeout: 1}
+	g.inFlight.Add(1)
+	defer g.inFlight.Done() // never reported drained; drainAndExit must give up on its own.
+
+	done := make(chan error, 1)
+	go func() {
+		done <- g.drainAndExit(context.Background(), &eventshub.EventLogs{}, nil)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("drainAndExit() returned before its 1s DrainTimeout elapsed")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("drainAndExit() error = %v, want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("drainAndExit() did not give up after its DrainTimeout elapsed")
+	}
+}
+
+func TestHasNextStopsWhenDraining(t *testing.T) {
+	g := &generator{}
+	if !g.hasNext() {
+		t.Fatal("hasNext() = false before draining, want true")
+	}
+	g.beginShutdown()
+	if g.hasNext() {
+		t.Error("hasNext() = true while draining, want false")
+	}
+}
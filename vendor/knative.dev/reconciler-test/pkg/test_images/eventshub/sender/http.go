@@ -0,0 +1,245 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sender
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	nethttp "net/http"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"go.opencensus.io/plugin/ochttp"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/tracing/propagation/tracecontextb3"
+
+	"knative.dev/reconciler-test/pkg/test_images/eventshub"
+)
+
+const (
+	distributionFixed   = "fixed"
+	distributionPoisson = "poisson"
+	distributionBurst   = "burst"
+)
+
+// pendingRequest pairs a prebuilt request with the event and sequence number it carries.
+type pendingRequest struct {
+	req   *nethttp.Request
+	event *cloudevents.Event
+	seq   int64
+}
+
+// runHTTP drives the HTTP sink protocol with g.Concurrency workers pulling prebuilt requests off
+// a shared channel that a single producer goroutine fills according to g.Distribution.
+func (g *generator) runHTTP(ctx context.Context, logs *eventshub.EventLogs, proto *httpProtocol) error {
+	if g.Distribution == distributionPoisson && g.Rate <= 0 {
+		return fmt.Errorf("DISTRIBUTION=poisson requires RATE > 0")
+	}
+
+	concurrency := g.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	producerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pending := make(chan pendingRequest)
+	go g.produce(producerCtx, pending)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrency)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		client := g.newHTTPClient()
+		go func() {
+			defer wg.Done()
+			for pr := range pending {
+				if err := g.sendOne(ctx, client, logs, pr); err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+	}
+
+	if ctx.Err() != nil {
+		logging.FromContext(ctx).Infof("Canceled sending messages because context was closed")
+		return nil
+	}
+	return nil
+}
+
+func (g *generator) newHTTPClient() *nethttp.Client {
+	client := &nethttp.Client{}
+	if g.AddTracing {
+		client.Transport = &ochttp.Transport{
+			Base:        nethttp.DefaultTransport,
+			Propagation: tracecontextb3.TraceContextEgress,
+		}
+	}
+	return client
+}
+
+func (g *generator) sendOne(ctx context.Context, client *nethttp.Client, logs *eventshub.EventLogs, pr pendingRequest) error {
+	sendCtx, span := g.startSendSpan(ctx, pr.event, pr.seq)
+
+	start := time.Now()
+	res, err := g.doWithRetry(sendCtx, client, pr.req, pr.seq, logs)
+	g.metrics.recordSent(err, time.Since(start))
+	var statusCode int
+	if res != nil {
+		statusCode = res.StatusCode
+	}
+
+	if err := logs.Vent(g.sentInfo(pr.event, pr.req.Header, pr.seq, err, span)); err != nil {
+		span.end(statusCode)
+		return fmt.Errorf("cannot forward event info: %w", err)
+	}
+	if err != nil {
+		span.end(statusCode)
+		return nil
+	}
+
+	ventErr := logs.Vent(g.responseInfo(cehttp.NewMessageFromHttpResponse(res), pr.event, pr.seq, span))
+	span.end(statusCode)
+	return ventErr
+}
+
+func (g *generator) produce(ctx context.Context, out chan<- pendingRequest) {
+	defer close(out)
+
+	switch g.Distribution {
+	case distributionPoisson:
+		g.producePoisson(ctx, out)
+	case distributionBurst:
+		g.produceBurst(ctx, out)
+	default:
+		g.produceFixed(ctx, out)
+	}
+}
+
+// produceFixed reproduces the sender's original fixed-period behavior; RATE, when set, overrides
+// PERIOD as the interval source.
+func (g *generator) produceFixed(ctx context.Context, out chan<- pendingRequest) {
+	interval := time.Duration(g.Period) * time.Second
+	if g.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / g.Rate)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if !g.emit(ctx, out) {
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// producePoisson draws inter-arrival times from an exponential distribution with mean 1/Rate.
+// Callers must validate Rate > 0 before reaching here; runHTTP does.
+func (g *generator) producePoisson(ctx context.Context, out chan<- pendingRequest) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for {
+		if !g.emit(ctx, out) {
+			return
+		}
+		wait := time.Duration(rng.ExpFloat64() / g.Rate * float64(time.Second))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// produceBurst emits BurstSize events back-to-back every BurstInterval.
+func (g *generator) produceBurst(ctx context.Context, out chan<- pendingRequest) {
+	size := g.BurstSize
+	if size < 1 {
+		size = 1
+	}
+	interval := g.BurstInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for i := 0; i < size; i++ {
+			if !g.emit(ctx, out) {
+				return
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// emit builds the next request, if any remain, and hands it to out.
+func (g *generator) emit(ctx context.Context, out chan<- pendingRequest) bool {
+	if !g.hasNext() {
+		return false
+	}
+
+	msg, event, seq, err := g.next(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Error("cannot build next request: ", err)
+		return false
+	}
+
+	req, err := nethttp.NewRequestWithContext(ctx, g.InputMethod, g.Sink, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error("cannot create request: ", err)
+		return false
+	}
+	if err := cehttp.WriteRequest(ctx, msg, req); err != nil {
+		logging.FromContext(ctx).Error("cannot write the event: ", err)
+		return false
+	}
+
+	select {
+	case out <- pendingRequest{req: req, event: event, seq: seq}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
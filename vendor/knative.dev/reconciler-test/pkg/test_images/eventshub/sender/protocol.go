@@ -0,0 +1,232 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sender
+
+import (
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	kafkasarama "github.com/cloudevents/sdk-go/protocol/kafka_sarama/v2"
+	natsprotocol "github.com/cloudevents/sdk-go/protocol/nats/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"go.opencensus.io/plugin/ochttp"
+	"knative.dev/pkg/tracing/propagation/tracecontextb3"
+
+	"knative.dev/reconciler-test/pkg/test_images/eventshub"
+)
+
+const (
+	protocolHTTP  = "http"
+	protocolNATS  = "nats"
+	protocolKafka = "kafka"
+)
+
+// Protocol abstracts delivery of a CloudEvent to a sink over a specific transport. Start probes
+// and closes every sink through it, and sends through it in replay mode and for NATS and Kafka;
+// generated HTTP sends go through runHTTP with its own clients instead.
+type Protocol interface {
+	// Send delivers msg to the sink. seq is the sequence number assigned to msg, for retryInfo.
+	Send(ctx context.Context, msg binding.Message, seq int64) (binding.Message, error)
+
+	Probe(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+func (g *generator) sendTracked(ctx context.Context, protocol Protocol, msg binding.Message, seq int64) (binding.Message, error) {
+	g.inFlight.Add(1)
+	defer g.inFlight.Done()
+	return protocol.Send(ctx, msg, seq)
+}
+
+// newProtocol builds the Protocol implementation selected by g.SinkProtocol, defaulting to HTTP.
+func (g *generator) newProtocol(ctx context.Context, logs *eventshub.EventLogs) (Protocol, error) {
+	switch g.SinkProtocol {
+	case "", protocolHTTP:
+		return newHTTPProtocol(g, logs), nil
+	case protocolNATS:
+		return newNATSProtocol(g)
+	case protocolKafka:
+		return newKafkaProtocol(g)
+	default:
+		return nil, fmt.Errorf("unsupported SINK_PROTOCOL: %q", g.SinkProtocol)
+	}
+}
+
+// httpProtocol sends events as HTTP requests, preserving the sender's original behavior,
+// including retry-with-backoff.
+type httpProtocol struct {
+	g      *generator
+	client *nethttp.Client
+	logs   *eventshub.EventLogs
+}
+
+func newHTTPProtocol(g *generator, logs *eventshub.EventLogs) *httpProtocol {
+	client := &nethttp.Client{}
+	if g.AddTracing {
+		client.Transport = &ochttp.Transport{
+			Base:        nethttp.DefaultTransport,
+			Propagation: tracecontextb3.TraceContextEgress,
+		}
+	}
+	return &httpProtocol{g: g, client: client, logs: logs}
+}
+
+func (p *httpProtocol) Send(ctx context.Context, msg binding.Message, seq int64) (binding.Message, error) {
+	req, err := nethttp.NewRequestWithContext(ctx, p.g.InputMethod, p.g.Sink, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := cehttp.WriteRequest(ctx, msg, req); err != nil {
+		return nil, fmt.Errorf("cannot write the event: %w", err)
+	}
+
+	res, err := p.g.doWithRetry(ctx, p.client, req, seq, p.logs)
+	if err != nil {
+		return nil, err
+	}
+	return cehttp.NewMessageFromHttpResponse(res), nil
+}
+
+func (p *httpProtocol) Probe(ctx context.Context) error {
+	req, err := nethttp.NewRequestWithContext(ctx, nethttp.MethodHead, p.g.Sink, nil)
+	if err != nil {
+		return err
+	}
+	_, err = nethttp.DefaultClient.Do(req)
+	return err
+}
+
+func (p *httpProtocol) Close(context.Context) error {
+	return nil
+}
+
+// natsProtocol publishes events to a NATS subject using the CloudEvents NATS binding.
+type natsProtocol struct {
+	sender  *natsprotocol.Sender
+	subject string
+}
+
+func newNATSProtocol(g *generator) (*natsProtocol, error) {
+	if g.SinkSubject == "" {
+		return nil, fmt.Errorf("SINK_SUBJECT is required when SINK_PROTOCOL=nats")
+	}
+	sender, err := natsprotocol.NewSender(g.Sink, g.SinkSubject, natsprotocol.NatsOptions())
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to NATS server %q: %w", g.Sink, err)
+	}
+	return &natsProtocol{sender: sender, subject: g.SinkSubject}, nil
+}
+
+// Send publishes msg, returning an ackMessage since NATS core publish has no response of its own.
+func (p *natsProtocol) Send(ctx context.Context, msg binding.Message, seq int64) (binding.Message, error) {
+	if err := p.sender.Send(ctx, msg); err != nil {
+		return nil, err
+	}
+	return &ackMessage{ack: fmt.Sprintf("published to subject=%s", p.subject)}, nil
+}
+
+func (p *natsProtocol) Probe(context.Context) error {
+	// The underlying connection is validated at construction time in newNATSProtocol.
+	return nil
+}
+
+func (p *natsProtocol) Close(ctx context.Context) error {
+	return p.sender.Close(ctx)
+}
+
+// kafkaProtocol publishes events to a Kafka topic via sarama.SyncProducer directly, so Send can
+// report back the partition/offset a message was written to.
+type kafkaProtocol struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+func newKafkaProtocol(g *generator) (*kafkaProtocol, error) {
+	if g.SinkTopic == "" {
+		return nil, fmt.Errorf("SINK_TOPIC is required when SINK_PROTOCOL=kafka")
+	}
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_0_0_0
+	config.Producer.Return.Successes = true
+
+	producer, err := sarama.NewSyncProducer(splitBrokerList(g.Sink), config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create Kafka producer for brokers %q: %w", g.Sink, err)
+	}
+	return &kafkaProtocol{producer: producer, topic: g.SinkTopic}, nil
+}
+
+// splitBrokerList splits a comma-separated SINK value into individual broker addresses.
+func splitBrokerList(sink string) []string {
+	parts := strings.Split(sink, ",")
+	brokers := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			brokers = append(brokers, p)
+		}
+	}
+	return brokers
+}
+
+func (p *kafkaProtocol) Send(ctx context.Context, msg binding.Message, seq int64) (binding.Message, error) {
+	saramaMsg := &sarama.ProducerMessage{Topic: p.topic}
+	if err := kafkasarama.WriteProducerMessage(ctx, msg, saramaMsg); err != nil {
+		return nil, fmt.Errorf("cannot write the event: %w", err)
+	}
+
+	partition, offset, err := p.producer.SendMessage(saramaMsg)
+	if err != nil {
+		return nil, err
+	}
+	return &ackMessage{ack: fmt.Sprintf("partition=%d offset=%d", partition, offset)}, nil
+}
+
+func (p *kafkaProtocol) Probe(context.Context) error {
+	// The sarama producer connects and validates brokers at construction time.
+	return nil
+}
+
+func (p *kafkaProtocol) Close(context.Context) error {
+	return p.producer.Close()
+}
+
+// ackMessage is a binding.Message carrying only transport acknowledgement metadata, for
+// protocols with no structured or binary response payload of their own.
+type ackMessage struct {
+	ack string
+}
+
+func (m *ackMessage) ReadEncoding() binding.Encoding {
+	return binding.EncodingUnknown
+}
+
+func (m *ackMessage) ReadStructured(context.Context, binding.StructuredWriter) error {
+	return binding.ErrNotStructured
+}
+
+func (m *ackMessage) ReadBinary(context.Context, binding.BinaryWriter) error {
+	return binding.ErrNotBinary
+}
+
+func (m *ackMessage) Finish(error) error {
+	return nil
+}
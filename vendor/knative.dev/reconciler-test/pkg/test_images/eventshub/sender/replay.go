@@ -0,0 +1,227 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sender
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	nethttp "net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"knative.dev/pkg/logging"
+
+	"knative.dev/reconciler-test/pkg/test_images/eventshub"
+)
+
+const inputModeReplay = "replay"
+
+// replayEvent is one recorded send, as read from InputEventsFile.
+type replayEvent struct {
+	DelayMs int64             `json:"delay_ms"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	Method  string            `json:"method"`
+}
+
+// loadReplayEvents reads InputEventsFile, accepting either a newline-delimited JSON file of
+// replayEvent objects, or a single JSON array -- either of replayEvent objects or of CloudEvents
+// JSON objects, i.e. a CloudEvents JSON batch.
+func loadReplayEvents(path string) ([]replayEvent, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		return parseReplayBatch(trimmed)
+	}
+	return parseReplayLines(trimmed)
+}
+
+func parseReplayLines(data []byte) ([]replayEvent, error) {
+	var events []replayEvent
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e replayEvent
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("invalid replay entry: %w", err)
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func parseReplayBatch(data []byte) ([]replayEvent, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid replay batch: %w", err)
+	}
+
+	events := make([]replayEvent, 0, len(raw))
+	for _, r := range raw {
+		var probe struct {
+			SpecVersion string `json:"specversion"`
+		}
+		if err := json.Unmarshal(r, &probe); err == nil && probe.SpecVersion != "" {
+			events = append(events, replayEvent{
+				Method:  nethttp.MethodPost,
+				Body:    string(r),
+				Headers: map[string]string{"Content-Type": "application/cloudevents+json"},
+			})
+			continue
+		}
+
+		var e replayEvent
+		if err := json.Unmarshal(r, &e); err != nil {
+			return nil, fmt.Errorf("invalid replay entry: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// nextReplayEntry returns the next recorded entry, advancing the shared replayIndex and looping
+// back to the start of the file when ReplayLoop is set.
+func (g *generator) nextReplayEntry() (replayEvent, int64, bool) {
+	total := int64(len(g.replayEvents))
+	if total == 0 {
+		return replayEvent{}, 0, false
+	}
+
+	idx := atomic.AddInt64(&g.replayIndex, 1) - 1
+	if idx >= total {
+		if !g.ReplayLoop {
+			return replayEvent{}, 0, false
+		}
+		idx %= total
+	}
+
+	seq := atomic.AddInt64(&g.sequence, 1)
+	return g.replayEvents[idx], seq, true
+}
+
+// replayDelay scales a recorded entry's delay by ReplaySpeed.
+func (g *generator) replayDelay(entry replayEvent) time.Duration {
+	speed := g.ReplaySpeed
+	if speed <= 0 {
+		speed = 1
+	}
+	return time.Duration(float64(entry.DelayMs) / speed * float64(time.Millisecond))
+}
+
+// buildReplayMessage turns a recorded entry into the message to send, and -- best-effort --
+// the CloudEvent it decodes to, so tracing and sentInfo/responseInfo look the same as they do
+// for generated sends.
+func (g *generator) buildReplayMessage(ctx context.Context, entry replayEvent) (binding.Message, *cloudevents.Event, nethttp.Header, error) {
+	method := entry.Method
+	if method == "" {
+		method = g.InputMethod
+	}
+
+	req, err := nethttp.NewRequest(method, g.Sink, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for k, v := range entry.Headers {
+		req.Header.Set(k, v)
+	}
+	if entry.Body != "" {
+		req.Body = ioutil.NopCloser(strings.NewReader(entry.Body))
+	}
+
+	msg := cehttp.NewMessageFromHttpRequest(req)
+	headers := req.Header.Clone()
+
+	var event *cloudevents.Event
+	if e, err := binding.ToEvent(ctx, msg); err == nil {
+		event = e
+		msg = binding.ToMessage(event)
+	}
+
+	return msg, event, headers, nil
+}
+
+// runReplay drives the send loop from InputEventsFile instead of cloning baseEvent, preserving
+// each recorded entry's delay (scaled by ReplaySpeed) rather than PERIOD/RATE.
+func (g *generator) runReplay(ctx context.Context, logs *eventshub.EventLogs, protocol Protocol) error {
+	for {
+		entry, seq, ok := g.nextReplayEntry()
+		if !ok {
+			return nil
+		}
+
+		msg, event, headers, err := g.buildReplayMessage(ctx, entry)
+		if err != nil {
+			return err
+		}
+
+		sendCtx, span := g.startSendSpan(ctx, event, seq)
+		start := time.Now()
+		res, err := g.sendTracked(sendCtx, protocol, msg, seq)
+		g.metrics.recordSent(err, time.Since(start))
+		var statusCode int
+		if httpMsg, ok := res.(*cehttp.Message); ok {
+			statusCode = httpMsg.StatusCode
+		}
+
+		if err := logs.Vent(g.sentInfo(event, headers, seq, err, span)); err != nil {
+			span.end(statusCode)
+			return fmt.Errorf("cannot forward event info: %w", err)
+		}
+		if err == nil && res != nil {
+			if err := logs.Vent(g.responseInfo(res, event, seq, span)); err != nil {
+				span.end(statusCode)
+				return fmt.Errorf("cannot forward event info: %w", err)
+			}
+		}
+		span.end(statusCode)
+
+		if !g.hasNext() {
+			return nil
+		}
+
+		select {
+		case <-time.After(g.replayDelay(entry)):
+			// Keep looping.
+		case <-ctx.Done():
+			logging.FromContext(ctx).Infof("Canceled replaying events because context was closed")
+			return nil
+		}
+		if g.isDraining() {
+			return nil
+		}
+	}
+}
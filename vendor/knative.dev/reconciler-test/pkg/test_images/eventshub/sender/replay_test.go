@@ -0,0 +1,95 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sender
+
+import (
+	"io/ioutil"
+	nethttp "net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadReplayEventsLines(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.ndjson")
+	data := "{\"delay_ms\":100,\"body\":\"one\"}\n\n{\"delay_ms\":200,\"body\":\"two\",\"method\":\"PUT\"}\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := loadReplayEvents(path)
+	if err != nil {
+		t.Fatalf("loadReplayEvents() error = %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("len(events) = %d, want 2", len(events))
+	}
+	if events[0].DelayMs != 100 || events[0].Body != "one" {
+		t.Errorf("events[0] = %+v, want delay_ms=100 body=one", events[0])
+	}
+	if events[1].Method != "PUT" {
+		t.Errorf("events[1].Method = %q, want PUT", events[1].Method)
+	}
+}
+
+func TestLoadReplayEventsMissingFile(t *testing.T) {
+	if _, err := loadReplayEvents(filepath.Join(t.TempDir(), "missing.ndjson")); err == nil {
+		t.Error("loadReplayEvents() error = nil, want an error for a missing file")
+	}
+}
+
+func TestParseReplayBatchOfReplayEvents(t *testing.T) {
+	data := `[{"delay_ms":50,"body":"a"},{"delay_ms":75,"body":"b","method":"PUT"}]`
+
+	events, err := parseReplayBatch([]byte(data))
+	if err != nil {
+		t.Fatalf("parseReplayBatch() error = %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("len(events) = %d, want 2", len(events))
+	}
+	if events[1].Method != "PUT" {
+		t.Errorf("events[1].Method = %q, want PUT", events[1].Method)
+	}
+}
+
+func TestParseReplayBatchOfCloudEvents(t *testing.T) {
+	data := `[{"specversion":"1.0","id":"1","source":"s","type":"t"}]`
+
+	events, err := parseReplayBatch([]byte(data))
+	if err != nil {
+		t.Fatalf("parseReplayBatch() error = %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("len(events) = %d, want 1", len(events))
+	}
+	if events[0].Method != nethttp.MethodPost {
+		t.Errorf("events[0].Method = %q, want POST", events[0].Method)
+	}
+	if events[0].Headers["Content-Type"] != "application/cloudevents+json" {
+		t.Errorf("events[0].Headers[Content-Type] = %q, want application/cloudevents+json", events[0].Headers["Content-Type"])
+	}
+	if events[0].Body != data[1:len(data)-1] {
+		t.Errorf("events[0].Body = %q, want the raw CloudEvent JSON", events[0].Body)
+	}
+}
+
+func TestParseReplayBatchInvalidJSON(t *testing.T) {
+	if _, err := parseReplayBatch([]byte("not json")); err == nil {
+		t.Error("parseReplayBatch() error = nil, want an error for invalid JSON")
+	}
+}
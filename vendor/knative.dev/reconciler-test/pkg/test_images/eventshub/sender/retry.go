@@ -0,0 +1,170 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sender
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	nethttp "net/http"
+	"time"
+
+	"knative.dev/pkg/logging"
+
+	"knative.dev/reconciler-test/pkg/test_images/eventshub"
+)
+
+const (
+	backoffLinear      = "linear"
+	backoffExponential = "exponential"
+	backoffConstant    = "constant"
+)
+
+// defaultRetryableCodes mirrors knative/eventing's kncloudevents.HTTPMessageSender.
+var defaultRetryableCodes = []int{404, 429, 500, 502, 503, 504}
+
+func (g *generator) doWithRetry(ctx context.Context, client *nethttp.Client, req *nethttp.Request, seq int64, logs *eventshub.EventLogs) (*nethttp.Response, error) {
+	var res *nethttp.Response
+	var doErr error
+
+	for attempt := 1; ; attempt++ {
+		attemptCtx, attemptSpan := g.startAttemptSpan(ctx, attempt)
+
+		attemptReq, err := cloneRequest(attemptCtx, req)
+		if err != nil {
+			attemptSpan.end(0)
+			return nil, err
+		}
+
+		g.inFlight.Add(1)
+		res, doErr = client.Do(attemptReq)
+		g.inFlight.Done()
+		var statusCode int
+		if res != nil {
+			statusCode = res.StatusCode
+		}
+
+		if !g.retryable(res, doErr) || attempt > g.RetryMax {
+			attemptSpan.end(statusCode)
+			return res, doErr
+		}
+
+		g.metrics.recordRetry()
+		prior := retryOutcome(res, doErr)
+		venErr := logs.Vent(g.retryInfo(attempt, prior, seq, attemptSpan))
+		attemptSpan.end(statusCode)
+		if venErr != nil {
+			return nil, fmt.Errorf("cannot forward retry event info: %w", venErr)
+		}
+		if res != nil && res.Body != nil {
+			res.Body.Close()
+		}
+
+		wait := g.backoff(attempt)
+		logging.FromContext(ctx).Infof("attempt %d failed (%s), retrying in %s", attempt, prior, wait)
+
+		select {
+		case <-time.After(wait):
+			// Keep looping.
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (g *generator) retryable(res *nethttp.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	codes := g.RetryRetryableCodes
+	if len(codes) == 0 {
+		codes = defaultRetryableCodes
+	}
+	for _, c := range codes {
+		if res.StatusCode == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *generator) backoff(attempt int) time.Duration {
+	initial := g.RetryInitial
+	if initial <= 0 {
+		initial = 200 * time.Millisecond
+	}
+
+	var d time.Duration
+	switch g.RetryBackoff {
+	case backoffLinear:
+		d = initial * time.Duration(attempt)
+	case backoffConstant:
+		d = initial
+	default: // backoffExponential, "" and any unrecognized value.
+		d = initial * time.Duration(math.Pow(2, float64(attempt-1)))
+	}
+
+	// Add up to 20% jitter so concurrent senders don't retry in lockstep.
+	return d + time.Duration(rand.Int63n(int64(d)/5+1))
+}
+
+func retryOutcome(res *nethttp.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("status %d", res.StatusCode)
+}
+
+func (g *generator) retryInfo(attempt int, prior string, seq int64, span *sendSpan) eventshub.EventInfo {
+	return eventshub.EventInfo{
+		Kind:     eventshub.EventSentRetry,
+		Origin:   g.SenderName,
+		Observer: g.SenderName,
+		Time:     time.Now(),
+		Sequence: uint64(seq),
+		Error:    fmt.Sprintf("attempt %d failed: %s", attempt, prior),
+		TraceId:  span.traceID,
+		SpanId:   span.spanID,
+	}
+}
+
+func cloneRequest(ctx context.Context, req *nethttp.Request) (*nethttp.Request, error) {
+	clone := req.Clone(ctx)
+	if req.Body == nil {
+		return clone, nil
+	}
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("cannot re-read request body for retry: %w", err)
+		}
+		clone.Body = body
+		return clone, nil
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot buffer request body for retry: %w", err)
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return clone, nil
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sender
+
+import (
+	"errors"
+	nethttp "net/http"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff string
+		initial time.Duration
+		attempt int
+		want    time.Duration
+	}{
+		{"exponential first attempt", backoffExponential, 100 * time.Millisecond, 1, 100 * time.Millisecond},
+		{"exponential third attempt", backoffExponential, 100 * time.Millisecond, 3, 400 * time.Millisecond},
+		{"linear third attempt", backoffLinear, 100 * time.Millisecond, 3, 300 * time.Millisecond},
+		{"constant fifth attempt", backoffConstant, 100 * time.Millisecond, 5, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &generator{RetryBackoff: tt.backoff, RetryInitial: tt.initial}
+			got := g.backoff(tt.attempt)
+			// backoff adds up to 20% jitter on top of the base duration.
+			if got < tt.want || got > tt.want+tt.want/5 {
+				t.Errorf("backoff(%d) = %s, want in [%s, %s]", tt.attempt, got, tt.want, tt.want+tt.want/5)
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	g := &generator{RetryRetryableCodes: []int{500, 503}}
+	tests := []struct {
+		name string
+		res  *nethttp.Response
+		err  error
+		want bool
+	}{
+		{"network error", nil, errors.New("connection reset"), true},
+		{"retryable status", &nethttp.Response{StatusCode: 503}, nil, true},
+		{"non-retryable status", &nethttp.Response{StatusCode: 200}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := g.retryable(tt.res, tt.err); got != tt.want {
+				t.Errorf("retryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryableDefaultCodes(t *testing.T) {
+	g := &generator{}
+	if !g.retryable(&nethttp.Response{StatusCode: 503}, nil) {
+		t.Error("retryable() = false, want true for the default retryable codes")
+	}
+	if g.retryable(&nethttp.Response{StatusCode: 201}, nil) {
+		t.Error("retryable() = true, want false for a successful status with no configured codes")
+	}
+}
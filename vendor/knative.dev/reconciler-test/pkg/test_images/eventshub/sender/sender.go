@@ -24,16 +24,16 @@ import (
 	"io/ioutil"
 	nethttp "net/http"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/cloudevents/sdk-go/v2/binding"
 	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
 	"github.com/kelseyhightower/envconfig"
-	"go.opencensus.io/plugin/ochttp"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"knative.dev/pkg/logging"
-	"knative.dev/pkg/tracing/propagation/tracecontextb3"
 
 	"knative.dev/reconciler-test/pkg/test_images/eventshub"
 )
@@ -41,9 +41,19 @@ import (
 type generator struct {
 	SenderName string `envconfig:"POD_NAME" default:"sender-default" required:"true"`
 
-	// Sink url for the message destination
+	// Sink is the destination for messages: an HTTP(S) URL, or a NATS/Kafka broker list when
+	// SinkProtocol selects a non-HTTP protocol.
 	Sink string `envconfig:"SINK" required:"true"`
 
+	// SinkProtocol selects the Protocol implementation used to deliver to Sink: http, nats or kafka.
+	SinkProtocol string `envconfig:"SINK_PROTOCOL" default:"http" required:"false"`
+
+	// SinkTopic is the Kafka topic to publish to when SinkProtocol is kafka.
+	SinkTopic string `envconfig:"SINK_TOPIC" required:"false"`
+
+	// SinkSubject is the NATS subject to publish to when SinkProtocol is nats.
+	SinkSubject string `envconfig:"SINK_SUBJECT" required:"false"`
+
 	// The number of seconds to wait before starting sending the first message
 	Delay int `envconfig:"DELAY" default:"5" required:"false"`
 
@@ -86,13 +96,102 @@ type generator struct {
 	// The number of messages to attempt to send. 0 for unlimited.
 	MaxMessages int `envconfig:"MAX_MESSAGES" default:"1" required:"false"`
 
+	// RetryMax is the maximum number of additional attempts made for a request that fails
+	// with a network error or a status in RetryRetryableCodes. 0 disables retries.
+	RetryMax int `envconfig:"RETRY_MAX" default:"0" required:"false"`
+
+	// RetryBackoff is the policy used to space out retry attempts: linear, exponential or constant.
+	RetryBackoff string `envconfig:"RETRY_BACKOFF" default:"exponential" required:"false"`
+
+	// RetryInitial is the wait duration before the first retry attempt.
+	RetryInitial time.Duration `envconfig:"RETRY_INITIAL" default:"200ms" required:"false"`
+
+	// RetryRetryableCodes is the set of HTTP status codes that trigger a retry. Defaults to
+	// the codes treated as transient by knative/eventing's kncloudevents.HTTPMessageSender.
+	RetryRetryableCodes []int `envconfig:"RETRY_RETRYABLE_CODES" default:"404,429,500,502,503,504" required:"false"`
+
+	// Rate is the target send rate in events/sec for the HTTP sink protocol. 0 falls back to
+	// the fixed Period-based ticker.
+	Rate float64 `envconfig:"RATE" default:"0" required:"false"`
+
+	// Distribution selects how send times are spaced out over the HTTP sink protocol: fixed
+	// (Rate if set, else Period), poisson (requires Rate) or burst (BurstSize/BurstInterval).
+	Distribution string `envconfig:"DISTRIBUTION" default:"fixed" required:"false"`
+
+	// BurstSize is the number of events sent back-to-back for DISTRIBUTION=burst.
+	BurstSize int `envconfig:"BURST_SIZE" default:"1" required:"false"`
+
+	// BurstInterval is the wait between bursts for DISTRIBUTION=burst.
+	BurstInterval time.Duration `envconfig:"BURST_INTERVAL" default:"1s" required:"false"`
+
+	// Concurrency is the number of goroutines concurrently sending over the HTTP sink protocol.
+	Concurrency int `envconfig:"CONCURRENCY" default:"1" required:"false"`
+
+	// TracingBackend selects which tracing SDK emits the per-send span: opencensus, opentelemetry
+	// or none.
+	TracingBackend string `envconfig:"TRACING_BACKEND" default:"none" required:"false"`
+
+	// InputTraceparent, given as a W3C traceparent header, seeds the first send span's parent so
+	// callers can assert trace propagation through the broker.
+	InputTraceparent string `envconfig:"INPUT_TRACEPARENT" required:"false"`
+
+	// InputMode selects where events originate: generated (clone InputEvent/InputBody as usual)
+	// or replay (read recorded events from InputEventsFile).
+	InputMode string `envconfig:"INPUT_MODE" default:"generated" required:"false"`
+
+	// InputEventsFile is a newline-delimited JSON file of {delay_ms, headers, body, method}
+	// entries, or a CloudEvents JSON batch, replayed when InputMode is replay.
+	InputEventsFile string `envconfig:"INPUT_EVENTS_FILE" required:"false"`
+
+	// ReplayLoop restarts InputEventsFile from the beginning once it is exhausted.
+	ReplayLoop bool `envconfig:"REPLAY_LOOP" default:"false" required:"false"`
+
+	// ReplaySpeed scales each recorded entry's delay_ms: 2 replays twice as fast, 0.5 half as fast.
+	ReplaySpeed float64 `envconfig:"REPLAY_SPEED" default:"1" required:"false"`
+
+	// AdminPort, if nonzero, serves /healthz, /readyz, /metrics and /shutdown so orchestrators
+	// can coordinate with this sender instead of guessing at timing.
+	AdminPort int `envconfig:"ADMIN_PORT" default:"0" required:"false"`
+
+	// DrainTimeout bounds how long drainAndExit waits for sends still tracked as in flight once
+	// the send loop has returned. The send loops finish the send they are making before they
+	// return, and that wait is not bounded by DrainTimeout.
+	DrainTimeout int `envconfig:"DRAIN_TIMEOUT" default:"30" required:"false"`
+
 	// --- Processed State ---
 
 	// baseEvent is parsed from InputEvent.
 	baseEvent *cloudevents.Event
 
-	// sequence is state counter for outbound events.
-	sequence int
+	// sequence is the state counter for outbound events, updated with atomic so that concurrent
+	// HTTP senders can share it safely.
+	sequence int64
+
+	// remoteParent is InputTraceparent, parsed by parseTraceParent.
+	remoteParent     *traceParent
+	remoteParentOnce sync.Once
+
+	// replayEvents is loaded from InputEventsFile by init when InputMode is replay.
+	replayEvents []replayEvent
+
+	// replayIndex is the next unread index into replayEvents, shared via atomic.
+	replayIndex int64
+
+	// initialized is set once init succeeds; it answers /healthz.
+	initialized int32
+
+	// ready is set once the sink probe succeeds; it answers /readyz.
+	ready int32
+
+	// draining is set once shutdown has been requested, by SIGTERM or a POST to /shutdown.
+	// hasNext checks it so every send loop stops starting new sends but lets in-flight ones finish.
+	draining int32
+
+	// inFlight tracks outstanding httpClient.Do calls so shutdown can wait for them to drain.
+	inFlight sync.WaitGroup
+
+	// metrics backs the /metrics endpoint.
+	metrics *sendMetrics
 }
 
 func Start(ctx context.Context, logs *eventshub.EventLogs) error {
@@ -103,9 +202,24 @@ func Start(ctx context.Context, logs *eventshub.EventLogs) error {
 	if err := env.init(); err != nil {
 		return err
 	}
+	atomic.StoreInt32(&env.initialized, 1)
+	env.metrics = newSendMetrics()
+
+	if err := env.parseTraceParent(); err != nil {
+		return err
+	}
 
 	logging.FromContext(ctx).Infof("Sender environment configuration: %+v", env)
 
+	stopShutdownWatcher := env.watchShutdownSignal()
+	defer stopShutdownWatcher()
+
+	admin, err := env.startAdminServer(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot start admin server: %w", err)
+	}
+	defer admin.shutdown(context.Background())
+
 	period := time.Duration(env.Period) * time.Second
 	delay := time.Duration(env.Delay) * time.Second
 
@@ -115,63 +229,87 @@ func Start(ctx context.Context, logs *eventshub.EventLogs) error {
 		logging.FromContext(ctx).Info("awake, continuing")
 	}
 
+	switch env.EventEncoding {
+	case "binary":
+		ctx = cloudevents.WithEncodingBinary(ctx)
+	case "structured":
+		ctx = cloudevents.WithEncodingStructured(ctx)
+	default:
+		return fmt.Errorf("unsupported encoding option: %q", env.EventEncoding)
+	}
+
+	protocol, err := env.newProtocol(ctx, logs)
+	if err != nil {
+		return fmt.Errorf("cannot set up sink protocol %q: %w", env.SinkProtocol, err)
+	}
+	defer func() {
+		if err := protocol.Close(ctx); err != nil {
+			logging.FromContext(ctx).Warn("error closing sink protocol: ", err)
+		}
+	}()
+
 	if env.ProbeSink {
 		probingTimeout := time.Duration(env.ProbeSinkTimeout) * time.Second
 		// Probe the sink for up to a minute.
 		if err := wait.PollImmediate(100*time.Millisecond, probingTimeout, func() (bool, error) {
-			req, err := nethttp.NewRequest(nethttp.MethodHead, env.Sink, nil)
-			if err != nil {
-				return false, err
-			}
-
-			if _, err := nethttp.DefaultClient.Do(req); err != nil {
-				return false, nil
-			}
-			return true, nil
+			return protocol.Probe(ctx) == nil, nil
 		}); err != nil {
 			return fmt.Errorf("probing the sink '%s' using timeout %s failed: %w", env.Sink, probingTimeout, err)
 		}
 	}
-
-	httpClient := &nethttp.Client{}
-	if env.AddTracing {
-		httpClient.Transport = &ochttp.Transport{
-			Base:        nethttp.DefaultTransport,
-			Propagation: tracecontextb3.TraceContextEgress,
-		}
+	atomic.StoreInt32(&env.ready, 1)
+
+	var runErr error
+	if env.InputMode == inputModeReplay {
+		runErr = env.runReplay(ctx, logs, protocol)
+	} else if httpProto, ok := protocol.(*httpProtocol); ok {
+		// The HTTP sink protocol supports concurrency and rate-limited arrival patterns, which
+		// are most useful for load-testing the broker's ingress; other protocols use the simple
+		// loop.
+		runErr = env.runHTTP(ctx, logs, httpProto)
+	} else {
+		runErr = env.runLoop(ctx, logs, protocol, period)
 	}
 
-	switch env.EventEncoding {
-	case "binary":
-		ctx = cloudevents.WithEncodingBinary(ctx)
-	case "structured":
-		ctx = cloudevents.WithEncodingStructured(ctx)
-	default:
-		return fmt.Errorf("unsupported encoding option: %q", env.EventEncoding)
-	}
+	return env.drainAndExit(ctx, logs, runErr)
+}
 
+// runLoop drives a single-goroutine, fixed-period send loop against protocol. It is used for
+// every sink protocol except HTTP, which has its own concurrency/rate-limiting-aware loop.
+func (g *generator) runLoop(ctx context.Context, logs *eventshub.EventLogs, protocol Protocol, period time.Duration) error {
 	ticker := time.NewTicker(period)
 	for {
-
-		req, event, err := env.next(ctx)
+		msg, event, seq, err := g.next(ctx)
 		if err != nil {
 			return err
 		}
 
-		res, err := httpClient.Do(req)
+		sendCtx, span := g.startSendSpan(ctx, event, seq)
+
+		start := time.Now()
+		res, err := g.sendTracked(sendCtx, protocol, msg, seq)
+		g.metrics.recordSent(err, time.Since(start))
+		var statusCode int
+		if httpMsg, ok := res.(*cehttp.Message); ok {
+			statusCode = httpMsg.StatusCode
+		}
+
 		// Publish sent event info
-		if err := logs.Vent(env.sentInfo(event, req, err)); err != nil {
+		if err := logs.Vent(g.sentInfo(event, nil, seq, err, span)); err != nil {
+			span.end(statusCode)
 			return fmt.Errorf("cannot forward event info: %w", err)
 		}
 
-		if err == nil {
+		if err == nil && res != nil {
 			// Vent the response info
-			if err := logs.Vent(env.responseInfo(res, event)); err != nil {
+			if err := logs.Vent(g.responseInfo(res, event, seq, span)); err != nil {
+				span.end(statusCode)
 				return fmt.Errorf("cannot forward event info: %w", err)
 			}
 		}
+		span.end(statusCode)
 
-		if !env.hasNext() {
+		if !g.hasNext() {
 			return nil
 		}
 
@@ -184,88 +322,101 @@ func Start(ctx context.Context, logs *eventshub.EventLogs) error {
 			logging.FromContext(ctx).Infof("Canceled sending messages because context was closed")
 			return nil
 		}
+		if g.isDraining() {
+			return nil
+		}
 	}
 }
 
-func (g *generator) sentInfo(event *cloudevents.Event, req *nethttp.Request, err error) eventshub.EventInfo {
+func (g *generator) sentInfo(event *cloudevents.Event, headers nethttp.Header, seq int64, err error, span *sendSpan) eventshub.EventInfo {
 	var eventId string
 	if event != nil {
 		eventId = event.ID()
 	}
 
-	if err != nil {
-		return eventshub.EventInfo{
-			Kind:     eventshub.EventSent,
-			Error:    err.Error(),
-			Origin:   g.SenderName,
-			Observer: g.SenderName,
-			Time:     time.Now(),
-			Sequence: uint64(g.sequence),
-			SentId:   eventId,
-		}
-	}
-
 	sentEventInfo := eventshub.EventInfo{
 		Kind:     eventshub.EventSent,
 		Event:    event,
 		Origin:   g.SenderName,
 		Observer: g.SenderName,
 		Time:     time.Now(),
-		Sequence: uint64(g.sequence),
+		Sequence: uint64(seq),
 		SentId:   eventId,
+		TraceId:  span.traceID,
+		SpanId:   span.spanID,
 	}
 
-	sentHeaders := make(nethttp.Header)
-	for k, v := range req.Header {
-		sentHeaders[k] = v
+	if err != nil {
+		sentEventInfo.Error = err.Error()
+		return sentEventInfo
 	}
-	sentEventInfo.HTTPHeaders = sentHeaders
 
+	if headers != nil {
+		sentEventInfo.HTTPHeaders = headers.Clone()
+	}
 	if g.InputBody != "" {
 		sentEventInfo.Body = []byte(g.InputBody)
 	}
 	return sentEventInfo
 }
 
-func (g *generator) responseInfo(res *nethttp.Response, event *cloudevents.Event) eventshub.EventInfo {
+// responseInfo reports whatever the sink protocol gave back for a send: HTTP status/headers and
+// the decoded response CloudEvent (if any) for the HTTP protocol, or the transport
+// acknowledgement metadata for protocols with no response message of their own, such as NATS
+// or Kafka.
+func (g *generator) responseInfo(msg binding.Message, event *cloudevents.Event, seq int64, span *sendSpan) eventshub.EventInfo {
 	var eventId string
 	if event != nil {
 		eventId = event.ID()
 	}
 
 	responseInfo := eventshub.EventInfo{
-		Kind:        eventshub.EventResponse,
-		HTTPHeaders: res.Header,
-		Origin:      g.Sink,
-		Observer:    g.SenderName,
-		Time:        time.Now(),
-		Sequence:    uint64(g.sequence),
-		StatusCode:  res.StatusCode,
-		SentId:      eventId,
+		Kind:     eventshub.EventResponse,
+		Origin:   g.Sink,
+		Observer: g.SenderName,
+		Time:     time.Now(),
+		Sequence: uint64(seq),
+		SentId:   eventId,
+		TraceId:  span.traceID,
+		SpanId:   span.spanID,
 	}
 
-	responseMessage := cehttp.NewMessageFromHttpResponse(res)
+	if httpMsg, ok := msg.(*cehttp.Message); ok {
+		responseInfo.HTTPHeaders = httpMsg.Header
+		responseInfo.StatusCode = httpMsg.StatusCode
+	}
 
-	if responseMessage.ReadEncoding() == binding.EncodingUnknown {
-		body, err := ioutil.ReadAll(res.Body)
+	if ack, ok := msg.(*ackMessage); ok {
+		responseInfo.Ack = ack.ack
+		return responseInfo
+	}
 
-		if err != nil {
-			responseInfo.Error = err.Error()
-		} else {
-			responseInfo.Body = body
-		}
+	if msg.ReadEncoding() == binding.EncodingUnknown {
+		return responseInfo
+	}
+
+	responseEvent, err := binding.ToEvent(context.Background(), msg)
+	if err != nil {
+		responseInfo.Error = err.Error()
 	} else {
-		responseEvent, err := binding.ToEvent(context.Background(), responseMessage)
-		if err != nil {
-			responseInfo.Error = err.Error()
-		} else {
-			responseInfo.Event = responseEvent
-		}
+		responseInfo.Event = responseEvent
 	}
 	return responseInfo
 }
 
 func (g *generator) init() error {
+	if g.InputMode == inputModeReplay {
+		if g.InputEventsFile == "" {
+			return fmt.Errorf("INPUT_EVENTS_FILE is required when INPUT_MODE=replay")
+		}
+		events, err := loadReplayEvents(g.InputEventsFile)
+		if err != nil {
+			return fmt.Errorf("cannot load replay events from %q: %w", g.InputEventsFile, err)
+		}
+		g.replayEvents = events
+		return nil
+	}
+
 	if g.InputEvent != "" {
 		if err := json.Unmarshal([]byte(g.InputEvent), &g.baseEvent); err != nil {
 			return fmt.Errorf("unable to unmarshal the event from json: %w", err)
@@ -280,42 +431,55 @@ func (g *generator) init() error {
 }
 
 func (g *generator) hasNext() bool {
-	if g.MaxMessages == 0 {
-		return true
+	if g.isDraining() {
+		return false
+	}
+	if g.MaxMessages != 0 && atomic.LoadInt64(&g.sequence) >= int64(g.MaxMessages) {
+		return false
 	}
-	return g.sequence < g.MaxMessages
+	if g.InputMode == inputModeReplay && !g.ReplayLoop {
+		return atomic.LoadInt64(&g.replayIndex) < int64(len(g.replayEvents))
+	}
+	return true
 }
 
-func (g *generator) next(ctx context.Context) (*nethttp.Request, *cloudevents.Event, error) {
-	req, err := nethttp.NewRequest(g.InputMethod, g.Sink, nil)
-	if err != nil {
-		logging.FromContext(ctx).Error("Cannot create the request: ", err)
-		return nil, nil, err
+// next produces the message for the next send. When a base CloudEvent was configured it is
+// cloned and stamped as usual; otherwise the raw InputHeaders/InputBody are sent as-is, which
+// only the HTTP protocol can make sense of.
+func (g *generator) next(ctx context.Context) (binding.Message, *cloudevents.Event, int64, error) {
+	if g.baseEvent == nil {
+		if g.SinkProtocol != "" && g.SinkProtocol != protocolHTTP {
+			return nil, nil, 0, fmt.Errorf("INPUT_EVENT is required when SINK_PROTOCOL=%s", g.SinkProtocol)
+		}
+		msg, err := g.nextRawHTTPMessage()
+		return msg, nil, 0, err
 	}
 
-	var event *cloudevents.Event
-	if g.baseEvent != nil {
-		e := g.baseEvent.Clone()
-		event = &e
+	e := g.baseEvent.Clone()
+	event := &e
 
-		g.sequence++
-		if g.AddSequence {
-			event.SetExtension("sequence", g.sequence)
-		}
-		if g.IncrementalId {
-			event.SetID(strconv.Itoa(g.sequence))
-		}
-		if g.OverrideTime {
-			event.SetTime(time.Now())
-		}
+	seq := atomic.AddInt64(&g.sequence, 1)
+	if g.AddSequence {
+		event.SetExtension("sequence", seq)
+	}
+	if g.IncrementalId {
+		event.SetID(strconv.FormatInt(seq, 10))
+	}
+	if g.OverrideTime {
+		event.SetTime(time.Now())
+	}
 
-		logging.FromContext(ctx).Info("I'm going to send\n", event)
+	logging.FromContext(ctx).Info("I'm going to send\n", event)
 
-		err := cehttp.WriteRequest(ctx, binding.ToMessage(event), req)
-		if err != nil {
-			logging.FromContext(ctx).Error("Cannot write the event: ", err)
-			return nil, nil, err
-		}
+	return binding.ToMessage(event), event, seq, nil
+}
+
+// nextRawHTTPMessage builds the message for the header/body-only input mode, used when no
+// InputEvent was configured.
+func (g *generator) nextRawHTTPMessage() (binding.Message, error) {
+	req, err := nethttp.NewRequest(g.InputMethod, g.Sink, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create request: %w", err)
 	}
 
 	if len(g.InputHeaders) != 0 {
@@ -328,5 +492,5 @@ func (g *generator) next(ctx context.Context) (*nethttp.Request, *cloudevents.Ev
 		req.Body = ioutil.NopCloser(bytes.NewReader([]byte(g.InputBody)))
 	}
 
-	return req, event, nil
+	return cehttp.NewMessageFromHttpRequest(req), nil
 }
@@ -0,0 +1,202 @@
+/*
+Copyright 2020 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sender
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"go.opencensus.io/trace"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracingBackendNone          = "none"
+	tracingBackendOpenCensus    = "opencensus"
+	tracingBackendOpenTelemetry = "opentelemetry"
+
+	sendSpanName    = "cloudevents.send"
+	attemptSpanName = sendSpanName + ".attempt"
+)
+
+var otelTracer = otel.Tracer("knative.dev/reconciler-test/pkg/test_images/eventshub/sender")
+
+// traceParent is the parsed form of a W3C traceparent header, used to seed the first send span.
+type traceParent struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+// sendSpan wraps whichever tracing backend is active, exposing the trace/span IDs for venting.
+type sendSpan struct {
+	traceID string
+	spanID  string
+	end     func(statusCode int)
+}
+
+func noopSpan(ctx context.Context) (context.Context, *sendSpan) {
+	return ctx, &sendSpan{end: func(int) {}}
+}
+
+// parseTraceParent decodes g.InputTraceparent, if set.
+func (g *generator) parseTraceParent() error {
+	if g.InputTraceparent == "" {
+		return nil
+	}
+
+	parts := strings.Split(g.InputTraceparent, "-")
+	if len(parts) != 4 {
+		return fmt.Errorf("traceparent %q must have 4 dash-separated fields", g.InputTraceparent)
+	}
+
+	var tp traceParent
+	if _, err := hex.Decode(tp.traceID[:], []byte(parts[1])); err != nil {
+		return fmt.Errorf("invalid trace-id in traceparent %q: %w", g.InputTraceparent, err)
+	}
+	if _, err := hex.Decode(tp.spanID[:], []byte(parts[2])); err != nil {
+		return fmt.Errorf("invalid parent-id in traceparent %q: %w", g.InputTraceparent, err)
+	}
+
+	g.remoteParent = &tp
+	return nil
+}
+
+// takeRemoteParent returns the parsed INPUT_TRACEPARENT exactly once.
+func (g *generator) takeRemoteParent() *traceParent {
+	if g.remoteParent == nil {
+		return nil
+	}
+	var used *traceParent
+	g.remoteParentOnce.Do(func() { used = g.remoteParent })
+	return used
+}
+
+func (g *generator) sinkProtocolName() string {
+	if g.SinkProtocol == "" {
+		return protocolHTTP
+	}
+	return g.SinkProtocol
+}
+
+// startSendSpan opens a span named "cloudevents.send" around one logical send, tagged with the
+// CloudEvent and messaging attributes.
+func (g *generator) startSendSpan(ctx context.Context, event *cloudevents.Event, seq int64) (context.Context, *sendSpan) {
+	if g.TracingBackend == "" || g.TracingBackend == tracingBackendNone {
+		return noopSpan(ctx)
+	}
+
+	return g.startSpan(ctx, sendSpanName, g.takeRemoteParent(), func(setString func(string, string), setInt func(string, int64)) {
+		setString("messaging.destination", g.Sink)
+		setString("messaging.system", g.sinkProtocolName())
+		setInt("eventshub.sequence", seq)
+		if event == nil {
+			return
+		}
+		setString("cloudevents.event_id", event.ID())
+		setString("cloudevents.event_type", event.Type())
+		setString("cloudevents.event_source", event.Source())
+	})
+}
+
+// startAttemptSpan opens a child span for a single delivery attempt inside doWithRetry.
+func (g *generator) startAttemptSpan(ctx context.Context, attempt int) (context.Context, *sendSpan) {
+	if g.TracingBackend == "" || g.TracingBackend == tracingBackendNone {
+		return noopSpan(ctx)
+	}
+
+	return g.startSpan(ctx, attemptSpanName, nil, func(_ func(string, string), setInt func(string, int64)) {
+		setInt("retry.attempt", int64(attempt))
+	})
+}
+
+// startSpan dispatches to the configured tracing backend's span implementation.
+func (g *generator) startSpan(ctx context.Context, name string, remote *traceParent, setAttrs func(setString func(string, string), setInt func(string, int64))) (context.Context, *sendSpan) {
+	switch g.TracingBackend {
+	case tracingBackendOpenCensus:
+		return startOpenCensusSpan(ctx, name, remote, setAttrs)
+	case tracingBackendOpenTelemetry:
+		return startOpenTelemetrySpan(ctx, name, remote, setAttrs)
+	default:
+		return noopSpan(ctx)
+	}
+}
+
+func startOpenCensusSpan(ctx context.Context, name string, remote *traceParent, setAttrs func(setString func(string, string), setInt func(string, int64))) (context.Context, *sendSpan) {
+	var spanCtx context.Context
+	var span *trace.Span
+
+	if remote != nil {
+		remoteSC := trace.SpanContext{TraceOptions: trace.TraceOptions(1)}
+		copy(remoteSC.TraceID[:], remote.traceID[:])
+		copy(remoteSC.SpanID[:], remote.spanID[:])
+		spanCtx, span = trace.StartSpanWithRemoteParent(ctx, name, remoteSC)
+	} else {
+		spanCtx, span = trace.StartSpan(ctx, name)
+	}
+
+	setAttrs(
+		func(k, v string) { span.AddAttributes(trace.StringAttribute(k, v)) },
+		func(k string, v int64) { span.AddAttributes(trace.Int64Attribute(k, v)) },
+	)
+
+	sc := span.SpanContext()
+	return spanCtx, &sendSpan{
+		traceID: sc.TraceID.String(),
+		spanID:  sc.SpanID.String(),
+		end: func(statusCode int) {
+			if statusCode != 0 {
+				span.AddAttributes(trace.Int64Attribute("http.status_code", int64(statusCode)))
+			}
+			span.End()
+		},
+	}
+}
+
+func startOpenTelemetrySpan(ctx context.Context, name string, remote *traceParent, setAttrs func(setString func(string, string), setInt func(string, int64))) (context.Context, *sendSpan) {
+	if remote != nil {
+		remoteSC := oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+			TraceID:    oteltrace.TraceID(remote.traceID),
+			SpanID:     oteltrace.SpanID(remote.spanID),
+			TraceFlags: oteltrace.FlagsSampled,
+			Remote:     true,
+		})
+		ctx = oteltrace.ContextWithRemoteSpanContext(ctx, remoteSC)
+	}
+
+	spanCtx, span := otelTracer.Start(ctx, name)
+	setAttrs(
+		func(k, v string) { span.SetAttributes(attribute.String(k, v)) },
+		func(k string, v int64) { span.SetAttributes(attribute.Int64(k, v)) },
+	)
+
+	sc := span.SpanContext()
+	return spanCtx, &sendSpan{
+		traceID: sc.TraceID().String(),
+		spanID:  sc.SpanID().String(),
+		end: func(statusCode int) {
+			if statusCode != 0 {
+				span.SetAttributes(attribute.Int("http.status_code", statusCode))
+			}
+			span.End()
+		},
+	}
+}